@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/go-redis/redis/v8"
+)
+
+// BlobBackend stores and retrieves blob content by its SHA-256 hash. The
+// filesystem backend is always available; an S3 backend can be selected
+// for deployments that want durable, off-box storage.
+type BlobBackend interface {
+	Write(hash string, data []byte) error
+	Read(hash string) ([]byte, error)
+	Delete(hash string) error
+}
+
+// filesystemBlobBackend stores blobs under dir, sharded by the first two
+// hex characters of the hash so no single directory gets too large.
+type filesystemBlobBackend struct {
+	dir string
+}
+
+func newFilesystemBlobBackend(dir string) (*filesystemBlobBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating blob dir %s: %v", dir, err)
+	}
+	return &filesystemBlobBackend{dir: dir}, nil
+}
+
+func (b *filesystemBlobBackend) path(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+func (b *filesystemBlobBackend) Write(hash string, data []byte) error {
+	path := b.path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (b *filesystemBlobBackend) Read(hash string) ([]byte, error) {
+	return ioutil.ReadFile(b.path(hash))
+}
+
+func (b *filesystemBlobBackend) Delete(hash string) error {
+	err := os.Remove(b.path(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// BlobMetadata is the Redis-backed record kept alongside each blob's
+// content so functions can look up size/type without touching the backend,
+// and so DELETE can tell when a blob is still referenced.
+type BlobMetadata struct {
+	ContentType string `json:"content_type" redis:"content_type"`
+	Size        int64  `json:"size" redis:"size"`
+	CreatedAt   int64  `json:"created_at" redis:"created_at"`
+	OwnerTopic  string `json:"owner_topic" redis:"owner_topic"`
+	RefCount    int64  `json:"ref_count" redis:"ref_count"`
+}
+
+func blobMetaKey(hash string) string {
+	return "blob:meta:" + hash
+}
+
+// BlobStore is the blob subsystem: content-addressed storage on a
+// BlobBackend, with ref-counted metadata in Redis so DELETE only removes
+// content nothing else still points at.
+type BlobStore struct {
+	backend       BlobBackend
+	redis         *redis.Client
+	maxUploadSize int64
+}
+
+func NewBlobStore(backend BlobBackend, client *redis.Client, maxUploadSize int64) *BlobStore {
+	return &BlobStore{
+		backend:       backend,
+		redis:         client,
+		maxUploadSize: maxUploadSize,
+	}
+}
+
+// Put stores data if it isn't already known, or bumps the ref count of an
+// existing blob with the same content, and returns its content-addressed
+// ID (the hex SHA-256 of data).
+func (s *BlobStore) Put(ctx context.Context, data []byte, contentType string, ownerTopic string) (string, error) {
+	if int64(len(data)) > s.maxUploadSize {
+		return "", fmt.Errorf("blob of %d bytes exceeds max upload size of %d bytes", len(data), s.maxUploadSize)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	key := blobMetaKey(hash)
+
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return "", fmt.Errorf("error checking blob metadata: %v", err)
+	}
+
+	if exists == 0 {
+		if err := s.backend.Write(hash, data); err != nil {
+			return "", fmt.Errorf("error writing blob content: %v", err)
+		}
+		err = s.redis.HSet(ctx, key,
+			"content_type", contentType,
+			"size", len(data),
+			"created_at", time.Now().Unix(),
+			"owner_topic", ownerTopic,
+			"ref_count", 1,
+		).Err()
+		if err != nil {
+			return "", fmt.Errorf("error writing blob metadata: %v", err)
+		}
+		return hash, nil
+	}
+
+	if err := s.redis.HIncrBy(ctx, key, "ref_count", 1).Err(); err != nil {
+		return "", fmt.Errorf("error incrementing blob ref count: %v", err)
+	}
+	return hash, nil
+}
+
+// Get returns a blob's content and metadata, or (nil, nil, nil) if it
+// doesn't exist.
+func (s *BlobStore) Get(ctx context.Context, hash string) ([]byte, *BlobMetadata, error) {
+	meta, err := s.metadata(ctx, hash)
+	if err != nil || meta == nil {
+		return nil, nil, err
+	}
+
+	data, err := s.backend.Read(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading blob content: %v", err)
+	}
+	return data, meta, nil
+}
+
+func (s *BlobStore) metadata(ctx context.Context, hash string) (*BlobMetadata, error) {
+	fields, err := s.redis.HGetAll(ctx, blobMetaKey(hash)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading blob metadata: %v", err)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	size, _ := strconv.ParseInt(fields["size"], 10, 64)
+	createdAt, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	refCount, _ := strconv.ParseInt(fields["ref_count"], 10, 64)
+
+	return &BlobMetadata{
+		ContentType: fields["content_type"],
+		Size:        size,
+		CreatedAt:   createdAt,
+		OwnerTopic:  fields["owner_topic"],
+		RefCount:    refCount,
+	}, nil
+}
+
+// Delete drops one reference to hash, removing the backing content and
+// metadata only once no references remain. It reports whether the blob
+// existed at all.
+func (s *BlobStore) Delete(ctx context.Context, hash string) (bool, error) {
+	key := blobMetaKey(hash)
+
+	// HIncrBy auto-vivifies the hash, so check existence first: otherwise
+	// deleting an ID that was never stored creates a ref_count=-1 hash,
+	// which a later Put's Exists check would then report as present.
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("error checking blob metadata: %v", err)
+	}
+	if exists == 0 {
+		return false, nil
+	}
+
+	refCount, err := s.redis.HIncrBy(ctx, key, "ref_count", -1).Result()
+	if err != nil {
+		return false, fmt.Errorf("error decrementing blob ref count: %v", err)
+	}
+
+	if refCount > 0 {
+		return true, nil
+	}
+
+	if err := s.backend.Delete(hash); err != nil {
+		return true, fmt.Errorf("error deleting blob content: %v", err)
+	}
+	if err := s.redis.Del(ctx, key).Err(); err != nil {
+		return true, fmt.Errorf("error deleting blob metadata: %v", err)
+	}
+	return true, nil
+}
+
+// blobModule exposes blob.put(bytes), blob.get(id), and blob.delete(id) so
+// functions can share large payloads without shoving them through pub/sub.
+type blobModule struct{}
+
+func (blobModule) Name() string { return "blob" }
+
+func (blobModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	store := ec.Blobs
+	loop := ec.Loop
+	current := ec.Current
+	ownerTopic := ec.Topic
+
+	blobBytes := func(val goja.Value) ([]byte, bool) {
+		switch v := val.Export().(type) {
+		case []byte:
+			return v, true
+		case string:
+			return []byte(v), true
+		case goja.ArrayBuffer:
+			return v.Bytes(), true
+		default:
+			return nil, false
+		}
+	}
+
+	blob := map[string]interface{}{
+		"put": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, reject := rt.NewPromise()
+
+			if len(call.Arguments) < 1 {
+				reject(rt.NewTypeError("blob.put requires one argument: bytes"))
+				return rt.ToValue(promise)
+			}
+			data, ok := blobBytes(call.Arguments[0])
+			if !ok {
+				reject(rt.NewTypeError("blob.put argument must be a string or ArrayBuffer"))
+				return rt.ToValue(promise)
+			}
+			contentType := "application/octet-stream"
+			if len(call.Arguments) > 1 && !goja.IsUndefined(call.Arguments[1]) {
+				contentType = call.Arguments[1].String()
+			}
+			ctx := current.ctx
+			execLogger := current.execLogger
+
+			go func() {
+				id, err := store.Put(ctx, data, contentType, ownerTopic)
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					if err != nil {
+						reject(rt.NewTypeError(err.Error()))
+						return
+					}
+					execLogger.Info().Str("blob_id", id).Int("size", len(data)).Msg("Stored blob")
+					resolve(rt.ToValue(id))
+				})
+			}()
+
+			return rt.ToValue(promise)
+		},
+		"get": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, reject := rt.NewPromise()
+
+			if len(call.Arguments) != 1 {
+				reject(rt.NewTypeError("blob.get requires one argument: id"))
+				return rt.ToValue(promise)
+			}
+			id := call.Arguments[0].String()
+			ctx := current.ctx
+
+			go func() {
+				data, meta, err := store.Get(ctx, id)
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					if err != nil {
+						reject(rt.NewTypeError(err.Error()))
+						return
+					}
+					if meta == nil {
+						resolve(goja.Null())
+						return
+					}
+					resolve(rt.ToValue(rt.NewArrayBuffer(data)))
+				})
+			}()
+
+			return rt.ToValue(promise)
+		},
+		"delete": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, reject := rt.NewPromise()
+
+			if len(call.Arguments) != 1 {
+				reject(rt.NewTypeError("blob.delete requires one argument: id"))
+				return rt.ToValue(promise)
+			}
+			id := call.Arguments[0].String()
+			ctx := current.ctx
+
+			go func() {
+				existed, err := store.Delete(ctx, id)
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					if err != nil {
+						reject(rt.NewTypeError(err.Error()))
+						return
+					}
+					resolve(rt.ToValue(existed))
+				})
+			}()
+
+			return rt.ToValue(promise)
+		},
+	}
+	rt.Set("blob", blob)
+
+	return nil
+}
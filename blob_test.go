@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFilesystemBlobBackendRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gofaas-blob-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := newFilesystemBlobBackend(dir)
+	if err != nil {
+		t.Fatalf("newFilesystemBlobBackend: %v", err)
+	}
+
+	const hash = "deadbeef"
+	want := []byte("hello blob")
+
+	if err := backend.Write(hash, want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got, err := backend.Read(hash)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("Read returned %q, want %q", got, want)
+	}
+
+	if err := backend.Delete(hash); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := backend.Read(hash); !os.IsNotExist(err) {
+		t.Errorf("Read after Delete returned err=%v, want a not-exist error", err)
+	}
+}
+
+func TestFilesystemBlobBackendDeleteMissingIsNotError(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gofaas-blob-test")
+	if err != nil {
+		t.Fatalf("MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := newFilesystemBlobBackend(dir)
+	if err != nil {
+		t.Fatalf("newFilesystemBlobBackend: %v", err)
+	}
+
+	if err := backend.Delete("never-written"); err != nil {
+		t.Errorf("Delete of a missing hash should be a no-op, got %v", err)
+	}
+}
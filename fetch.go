@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+)
+
+// FetchConfig controls outbound HTTP access from JS functions via fetch().
+// It is loaded once at startup from a JSON file (see loadFetchConfig) and
+// shared by every function's fetch binding.
+type FetchConfig struct {
+	TimeoutMS            int64    `json:"timeout_ms"`
+	AllowedHosts         []string `json:"allowed_hosts"`
+	InsecureSkipVerify   bool     `json:"insecure_skip_verify"`
+	ProxyURL             string   `json:"proxy_url"`
+	MaxConcurrentFetches int      `json:"max_concurrent_fetches"`
+}
+
+// defaultFetchConfig is used when no config file is present. An empty
+// AllowedHosts means "allow any host".
+func defaultFetchConfig() *FetchConfig {
+	return &FetchConfig{
+		TimeoutMS:            10000,
+		MaxConcurrentFetches: 50,
+	}
+}
+
+// loadFetchConfig reads fetch settings from path. A missing file is not an
+// error: it just means the defaults apply.
+func loadFetchConfig(path string) (*FetchConfig, error) {
+	cfg := defaultFetchConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("error reading fetch config %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing fetch config %s: %v", path, err)
+	}
+
+	if cfg.TimeoutMS <= 0 {
+		cfg.TimeoutMS = defaultFetchConfig().TimeoutMS
+	}
+	if cfg.MaxConcurrentFetches <= 0 {
+		cfg.MaxConcurrentFetches = defaultFetchConfig().MaxConcurrentFetches
+	}
+
+	return cfg, nil
+}
+
+func (c *FetchConfig) httpClient() (*http.Client, error) {
+	transport := &http.Transport{}
+
+	if c.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(c.TimeoutMS) * time.Millisecond,
+		Transport: transport,
+	}, nil
+}
+
+func (c *FetchConfig) hostAllowed(host string) bool {
+	if len(c.AllowedHosts) == 0 {
+		return true
+	}
+	hostOnly := host
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		hostOnly = host[:idx]
+	}
+	for _, allowed := range c.AllowedHosts {
+		if strings.EqualFold(allowed, hostOnly) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchSemaphore caps the number of outbound requests in flight across all
+// functions, so a runaway function cannot exhaust file descriptors.
+var fetchSemaphore chan struct{}
+
+func initFetchSemaphore(limit int) {
+	fetchSemaphore = make(chan struct{}, limit)
+}
+
+// registerFetch wires a fetch(url, options) binding onto rt. Promise
+// settlement happens by scheduling back onto loop, since rt is only safe to
+// touch from its own event loop goroutine.
+func registerFetch(rt *goja.Runtime, loop *eventloop.EventLoop, client *http.Client, cfg *FetchConfig, current *execState) {
+	rt.Set("fetch", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) < 1 {
+			panic(rt.NewTypeError("fetch requires at least one argument: url"))
+		}
+
+		reqURL := call.Arguments[0].String()
+
+		method := "GET"
+		var body string
+		headers := map[string]string{}
+
+		if len(call.Arguments) > 1 && !goja.IsUndefined(call.Arguments[1]) {
+			opts := call.Arguments[1].ToObject(rt)
+			if m := opts.Get("method"); m != nil && !goja.IsUndefined(m) {
+				method = strings.ToUpper(m.String())
+			}
+			if b := opts.Get("body"); b != nil && !goja.IsUndefined(b) {
+				body = b.String()
+			}
+			if h := opts.Get("headers"); h != nil && !goja.IsUndefined(h) {
+				if hObj, ok := h.Export().(map[string]interface{}); ok {
+					for k, v := range hObj {
+						headers[k] = fmt.Sprintf("%v", v)
+					}
+				}
+			}
+		}
+
+		promise, resolve, reject := rt.NewPromise()
+
+		parsed, err := url.Parse(reqURL)
+		if err != nil {
+			reject(rt.NewTypeError(fmt.Sprintf("invalid URL: %v", err)))
+			return rt.ToValue(promise)
+		}
+		if !cfg.hostAllowed(parsed.Host) {
+			reject(rt.NewTypeError(fmt.Sprintf("host not allowed: %s", parsed.Host)))
+			return rt.ToValue(promise)
+		}
+
+		execLogger := current.execLogger
+		ctx := current.ctx
+
+		go func() {
+			fetchSemaphore <- struct{}{}
+			defer func() { <-fetchSemaphore }()
+
+			start := time.Now()
+			// current.ctx carries the execution's deadline, so a timeout
+			// cancels this request too instead of leaving it running
+			// against an already-abandoned execution (client.Timeout alone
+			// only bounds the request, not the function's own deadline).
+			httpReq, err := http.NewRequestWithContext(ctx, method, reqURL, strings.NewReader(body))
+			if err != nil {
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					reject(rt.NewTypeError(fmt.Sprintf("failed to build request: %v", err)))
+				})
+				return
+			}
+			for k, v := range headers {
+				httpReq.Header.Set(k, v)
+			}
+
+			resp, err := client.Do(httpReq)
+			duration := time.Since(start)
+			if err != nil {
+				execLogger.Error().
+					Str("method", method).
+					Str("host", parsed.Host).
+					Dur("duration", duration).
+					Err(err).
+					Msg("fetch failed")
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					reject(rt.NewTypeError(fmt.Sprintf("fetch failed: %v", err)))
+				})
+				return
+			}
+			defer resp.Body.Close()
+
+			respBody, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				execLogger.Error().
+					Str("method", method).
+					Str("host", parsed.Host).
+					Dur("duration", duration).
+					Err(err).
+					Msg("fetch failed reading response body")
+				loop.RunOnLoop(func(rt *goja.Runtime) {
+					reject(rt.NewTypeError(fmt.Sprintf("failed to read response body: %v", err)))
+				})
+				return
+			}
+
+			execLogger.Info().
+				Str("method", method).
+				Str("host", parsed.Host).
+				Int("status", resp.StatusCode).
+				Dur("duration", duration).
+				Msg("fetch completed")
+
+			loop.RunOnLoop(func(rt *goja.Runtime) {
+				resolve(rt.ToValue(buildFetchResponse(rt, resp, respBody)))
+			})
+		}()
+
+		return rt.ToValue(promise)
+	})
+}
+
+// buildFetchResponse returns a JS object shaped like a subset of the
+// standard Response interface: status, headers, and text()/json()/
+// arrayBuffer() accessors over the already-buffered body.
+func buildFetchResponse(rt *goja.Runtime, resp *http.Response, body []byte) map[string]interface{} {
+	headers := map[string]interface{}{}
+	for k := range resp.Header {
+		headers[k] = resp.Header.Get(k)
+	}
+
+	return map[string]interface{}{
+		"status":  resp.StatusCode,
+		"ok":      resp.StatusCode >= 200 && resp.StatusCode < 300,
+		"headers": headers,
+		"text": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, _ := rt.NewPromise()
+			resolve(rt.ToValue(string(body)))
+			return rt.ToValue(promise)
+		},
+		"json": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, reject := rt.NewPromise()
+			var parsed interface{}
+			if err := json.Unmarshal(body, &parsed); err != nil {
+				reject(rt.NewTypeError(fmt.Sprintf("failed to parse JSON: %v", err)))
+			} else {
+				resolve(rt.ToValue(parsed))
+			}
+			return rt.ToValue(promise)
+		},
+		"arrayBuffer": func(call goja.FunctionCall) goja.Value {
+			promise, resolve, _ := rt.NewPromise()
+			resolve(rt.ToValue(rt.NewArrayBuffer(body)))
+			return rt.ToValue(promise)
+		},
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestFetchConfigHostAllowedNoAllowList(t *testing.T) {
+	cfg := &FetchConfig{}
+
+	if !cfg.hostAllowed("example.com") {
+		t.Error("expected any host to be allowed when AllowedHosts is empty")
+	}
+}
+
+func TestFetchConfigHostAllowedMatch(t *testing.T) {
+	cfg := &FetchConfig{AllowedHosts: []string{"Example.com", "api.internal"}}
+
+	if !cfg.hostAllowed("example.com") {
+		t.Error("expected example.com to match case-insensitively")
+	}
+	if !cfg.hostAllowed("api.internal:8443") {
+		t.Error("expected a port suffix to be stripped before matching")
+	}
+}
+
+func TestFetchConfigHostAllowedReject(t *testing.T) {
+	cfg := &FetchConfig{AllowedHosts: []string{"api.internal"}}
+
+	if cfg.hostAllowed("evil.com") {
+		t.Error("expected a host outside AllowedHosts to be rejected")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	keyspaceChannelPattern = "__keyspace@0__:*"
+	keyspacePrefix         = "__keyspace@0__:"
+
+	// maxWatchersPerTopic caps how many watchKey registrations a single
+	// function can hold open at once, so a runaway handler can't leak an
+	// unbounded number of subscriptions onto the shared watcher.
+	maxWatchersPerTopic = 20
+
+	keyWatcherMinBackoff = 500 * time.Millisecond
+	keyWatcherMaxBackoff = 30 * time.Second
+)
+
+// keyWatchRegistration is one watchKey() call: a glob pattern to match
+// against changed keys, and the event-loop job to run when it matches, so
+// the handler executes on its owning function's runtime instead of the
+// shared dispatch goroutine.
+type keyWatchRegistration struct {
+	topic   string
+	pattern string
+	loop    *eventloop.EventLoop
+	handler func(rt *goja.Runtime, event string, key string)
+}
+
+// KeyWatcher is the shared subsystem behind watchKey(), modeled on
+// gitlab-workhorse's keywatcher: one PSubscribe to Redis's keyspace
+// notification channel for the whole process, fanning out to every
+// registered callback whose pattern matches the changed key. A dropped
+// connection is retried with backoff rather than silently going dark.
+type KeyWatcher struct {
+	client *redis.Client
+
+	mu            sync.Mutex
+	subs          map[string]*keyWatchRegistration
+	watcherCounts map[string]int
+	nextID        uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewKeyWatcher(client *redis.Client) *KeyWatcher {
+	return &KeyWatcher{
+		client:        client,
+		subs:          make(map[string]*keyWatchRegistration),
+		watcherCounts: make(map[string]int),
+	}
+}
+
+// Start begins the single background subscription. It should be called
+// once at startup, before any function registers a watchKey().
+func (kw *KeyWatcher) Start(ctx context.Context) {
+	kw.ctx, kw.cancel = context.WithCancel(ctx)
+	go kw.run()
+}
+
+// Shutdown stops the background subscription.
+func (kw *KeyWatcher) Shutdown() {
+	if kw.cancel != nil {
+		kw.cancel()
+	}
+}
+
+func (kw *KeyWatcher) run() {
+	backoff := keyWatcherMinBackoff
+
+	for {
+		select {
+		case <-kw.ctx.Done():
+			return
+		default:
+		}
+
+		pubsub := kw.client.PSubscribe(kw.ctx, keyspaceChannelPattern)
+		if _, err := pubsub.Receive(kw.ctx); err != nil {
+			pubsub.Close()
+			if kw.ctx.Err() != nil {
+				return
+			}
+
+			appLogger.Warn().Err(err).Dur("backoff", backoff).Msg("Keyspace watcher subscribe failed, retrying")
+			select {
+			case <-time.After(backoff):
+			case <-kw.ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > keyWatcherMaxBackoff {
+				backoff = keyWatcherMaxBackoff
+			}
+			continue
+		}
+
+		backoff = keyWatcherMinBackoff
+		kw.dispatch(pubsub.Channel())
+		pubsub.Close()
+
+		if kw.ctx.Err() != nil {
+			return
+		}
+		appLogger.Warn().Msg("Keyspace watcher channel closed, resubscribing")
+	}
+}
+
+// dispatch matches each incoming notification against every registered
+// pattern and runs the matching handlers on their owning function's event
+// loop, so a slow handler can only ever block its own function.
+func (kw *KeyWatcher) dispatch(ch <-chan *redis.Message) {
+	for msg := range ch {
+		key := strings.TrimPrefix(msg.Channel, keyspacePrefix)
+		event := msg.Payload
+
+		kw.mu.Lock()
+		var matched []*keyWatchRegistration
+		for _, reg := range kw.subs {
+			if ok, _ := path.Match(reg.pattern, key); ok {
+				matched = append(matched, reg)
+			}
+		}
+		kw.mu.Unlock()
+
+		for _, reg := range matched {
+			reg := reg
+			reg.loop.RunOnLoop(func(rt *goja.Runtime) {
+				reg.handler(rt, event, key)
+			})
+		}
+	}
+}
+
+// WatchKey registers handler to run whenever a key matching pattern
+// changes, returning a watch ID that can later be passed to UnwatchKey.
+func (kw *KeyWatcher) WatchKey(topic string, pattern string, loop *eventloop.EventLoop, handler func(rt *goja.Runtime, event string, key string)) (string, error) {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	if kw.watcherCounts[topic] >= maxWatchersPerTopic {
+		return "", fmt.Errorf("topic %s has reached the maximum of %d key watchers", topic, maxWatchersPerTopic)
+	}
+
+	kw.nextID++
+	id := fmt.Sprintf("watch_%d", kw.nextID)
+	kw.subs[id] = &keyWatchRegistration{
+		topic:   topic,
+		pattern: pattern,
+		loop:    loop,
+		handler: handler,
+	}
+	kw.watcherCounts[topic]++
+
+	return id, nil
+}
+
+// UnwatchKey cancels a single registration by the ID WatchKey returned.
+func (kw *KeyWatcher) UnwatchKey(id string) {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	reg, ok := kw.subs[id]
+	if !ok {
+		return
+	}
+	kw.watcherCounts[reg.topic]--
+	delete(kw.subs, id)
+}
+
+// UnwatchAllForTopic cancels every registration owned by topic. It is
+// called when a function's subscription ends, so stale registrations
+// don't keep pointing at a stopped event loop.
+func (kw *KeyWatcher) UnwatchAllForTopic(topic string) {
+	kw.mu.Lock()
+	defer kw.mu.Unlock()
+
+	for id, reg := range kw.subs {
+		if reg.topic == topic {
+			delete(kw.subs, id)
+		}
+	}
+	delete(kw.watcherCounts, topic)
+}
+
+// keyWatchModule exposes watchKey(pattern, handler) and unwatchKey(id) so
+// functions can react to KV changes instead of only the explicit publish
+// model.
+type keyWatchModule struct{}
+
+func (keyWatchModule) Name() string { return "keywatch" }
+
+func (keyWatchModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	watcher := ec.KeyWatcher
+	topic := ec.Topic
+	loop := ec.Loop
+	current := ec.Current
+	baseCtx := ec.Ctx
+	limitsStore := ec.LimitsStore
+
+	rt.Set("watchKey", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 2 {
+			panic(rt.NewTypeError("watchKey requires two arguments: pattern and handler"))
+		}
+
+		pattern := call.Arguments[0].String()
+		handlerFn, ok := goja.AssertFunction(call.Arguments[1])
+		if !ok {
+			panic(rt.NewTypeError("watchKey requires a function as its second argument"))
+		}
+
+		id, err := watcher.WatchKey(topic, pattern, loop, func(rt *goja.Runtime, event string, key string) {
+			// A keyspace event can fire between ordinary messages, so it
+			// must not log under (or append console output to) whatever
+			// message happened to run last: give it its own logger and
+			// console-output slot, temporarily swapped into current, and
+			// flush them itself instead of relying on the message loop's
+			// finish(), which won't run again until the next message.
+			watchLogger := messageLogger.With().
+				Str("topic", topic).
+				Str("pattern", pattern).
+				Str("key", key).
+				Str("event", event).
+				Logger()
+
+			prevLogger := current.execLogger
+			prevConsole := current.consoleOutput
+			prevCorrelationID := current.correlationID
+			prevReplyTo := current.replyTo
+			prevCtx := current.ctx
+
+			// Host bindings the handler calls (storeKey, publish, blob.*,
+			// ...) all read current.ctx, so it needs its own deadline here
+			// too, the same way the per-message path in subscribeToRedis
+			// arms one: otherwise it's either nil (before the first
+			// ordinary message) or whatever deadline the last message's
+			// finish() already cancelled.
+			deadlineCtx, cancelDeadline := context.WithTimeout(baseCtx, limitsStore.Get(topic).timeout())
+
+			current.execLogger = watchLogger
+			current.consoleOutput = nil
+			current.correlationID = generateCorrelationID()
+			current.replyTo = ""
+			current.ctx = deadlineCtx
+
+			finish := func(err error) {
+				cancelDeadline()
+				if err != nil {
+					watchLogger.Error().Err(err).Strs("console_output", current.consoleOutput).Msg("watchKey handler failed")
+				} else {
+					watchLogger.Info().Strs("console_output", current.consoleOutput).Msg("watchKey handler completed")
+				}
+				current.execLogger = prevLogger
+				current.consoleOutput = prevConsole
+				current.correlationID = prevCorrelationID
+				current.replyTo = prevReplyTo
+				current.ctx = prevCtx
+			}
+
+			result, err := handlerFn(goja.Undefined(), rt.ToValue(key), rt.ToValue(event))
+			if err != nil {
+				finish(err)
+				return
+			}
+			if isPromise(result) {
+				awaitPromise(rt, result, func(_ goja.Value, err error) {
+					finish(err)
+				})
+				return
+			}
+			finish(nil)
+		})
+		if err != nil {
+			panic(rt.NewTypeError(err.Error()))
+		}
+
+		current.execLogger.Info().Str("pattern", pattern).Str("watch_id", id).Msg("Registered key watcher")
+		return rt.ToValue(id)
+	})
+
+	rt.Set("unwatchKey", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 1 {
+			panic(rt.NewTypeError("unwatchKey requires one argument: watch id"))
+		}
+		watcher.UnwatchKey(call.Arguments[0].String())
+		return goja.Undefined()
+	})
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dop251/goja"
+)
+
+func noopKeyWatchHandler(rt *goja.Runtime, event string, key string) {}
+
+func TestKeyWatcherWatchAndUnwatch(t *testing.T) {
+	kw := NewKeyWatcher(nil)
+
+	id, err := kw.WatchKey("topic-a", "foo:*", nil, noopKeyWatchHandler)
+	if err != nil {
+		t.Fatalf("WatchKey: %v", err)
+	}
+	if _, ok := kw.subs[id]; !ok {
+		t.Fatalf("expected registration %q to be recorded", id)
+	}
+	if kw.watcherCounts["topic-a"] != 1 {
+		t.Errorf("watcherCounts[topic-a] = %d, want 1", kw.watcherCounts["topic-a"])
+	}
+
+	kw.UnwatchKey(id)
+	if _, ok := kw.subs[id]; ok {
+		t.Errorf("expected registration %q to be removed after UnwatchKey", id)
+	}
+	if kw.watcherCounts["topic-a"] != 0 {
+		t.Errorf("watcherCounts[topic-a] = %d, want 0 after UnwatchKey", kw.watcherCounts["topic-a"])
+	}
+}
+
+func TestKeyWatcherWatchKeyEnforcesPerTopicCap(t *testing.T) {
+	kw := NewKeyWatcher(nil)
+
+	for i := 0; i < maxWatchersPerTopic; i++ {
+		if _, err := kw.WatchKey("topic-b", "foo:*", nil, noopKeyWatchHandler); err != nil {
+			t.Fatalf("WatchKey #%d: %v", i, err)
+		}
+	}
+
+	if _, err := kw.WatchKey("topic-b", "foo:*", nil, noopKeyWatchHandler); err == nil {
+		t.Error("expected WatchKey to reject registration past maxWatchersPerTopic")
+	}
+}
+
+func TestKeyWatcherUnwatchAllForTopic(t *testing.T) {
+	kw := NewKeyWatcher(nil)
+
+	idA, _ := kw.WatchKey("topic-c", "foo:*", nil, noopKeyWatchHandler)
+	idB, _ := kw.WatchKey("topic-c", "bar:*", nil, noopKeyWatchHandler)
+	idOther, _ := kw.WatchKey("topic-d", "baz:*", nil, noopKeyWatchHandler)
+
+	kw.UnwatchAllForTopic("topic-c")
+
+	if _, ok := kw.subs[idA]; ok {
+		t.Errorf("expected %q to be removed by UnwatchAllForTopic", idA)
+	}
+	if _, ok := kw.subs[idB]; ok {
+		t.Errorf("expected %q to be removed by UnwatchAllForTopic", idB)
+	}
+	if _, ok := kw.subs[idOther]; !ok {
+		t.Errorf("expected %q owned by a different topic to survive", idOther)
+	}
+	if _, ok := kw.watcherCounts["topic-c"]; ok {
+		t.Error("expected watcherCounts entry for topic-c to be removed")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ExecutionLimits bounds a single function invocation: how long it may run
+// and how much memory its runtime may allocate.
+type ExecutionLimits struct {
+	TimeoutMS        int64 `json:"timeout_ms"`
+	MemoryLimitBytes int64 `json:"memory_limit_bytes"`
+}
+
+func defaultExecutionLimits() ExecutionLimits {
+	return ExecutionLimits{
+		TimeoutMS:        5000,
+		MemoryLimitBytes: 64 * 1024 * 1024,
+	}
+}
+
+func (l ExecutionLimits) timeout() time.Duration {
+	return time.Duration(l.TimeoutMS) * time.Millisecond
+}
+
+// estimatedBytesPerCallFrame is a rough stand-in for a JS call frame's
+// memory footprint. goja has no heap-accounting API, so MemoryLimitBytes
+// can't be enforced directly; this converts it into the one memory-shaped
+// knob goja does expose (maximum call depth), which at least bounds the
+// unbounded-recursion case that a real memory limit would also catch.
+const estimatedBytesPerCallFrame = 8 * 1024
+
+const minCallStackSize = 64
+
+// maxCallStackSize derives a call-depth cap from MemoryLimitBytes for
+// rt.SetMaxCallStackSize.
+func (l ExecutionLimits) maxCallStackSize() int {
+	size := int(l.MemoryLimitBytes / estimatedBytesPerCallFrame)
+	if size < minCallStackSize {
+		return minCallStackSize
+	}
+	return size
+}
+
+// LimitsStore holds the live execution limits for each topic, keyed the
+// same way FunctionStore keys functions. Limits are seeded from a
+// function's manifest.json at (re)load time, but can also be changed live
+// via the /api/functions/:topic/limits endpoint without redeploying code.
+type LimitsStore struct {
+	sync.RWMutex
+	limits map[string]ExecutionLimits
+}
+
+func NewLimitsStore() *LimitsStore {
+	return &LimitsStore{
+		limits: make(map[string]ExecutionLimits),
+	}
+}
+
+func (ls *LimitsStore) Get(topic string) ExecutionLimits {
+	ls.RLock()
+	defer ls.RUnlock()
+	if l, ok := ls.limits[topic]; ok {
+		return l
+	}
+	return defaultExecutionLimits()
+}
+
+func (ls *LimitsStore) Set(topic string, limits ExecutionLimits) {
+	ls.Lock()
+	defer ls.Unlock()
+	ls.limits[topic] = limits
+}
+
+func (ls *LimitsStore) Delete(topic string) {
+	ls.Lock()
+	defer ls.Unlock()
+	delete(ls.limits, topic)
+}
+
+// FunctionManifest is an optional manifest.json next to a function's
+// func.js overriding the default execution limits for that topic.
+type FunctionManifest struct {
+	TimeoutMS        int64    `json:"timeout_ms,omitempty"`
+	MemoryLimitBytes int64    `json:"memory_limit_bytes,omitempty"`
+	Modules          []string `json:"modules,omitempty"`
+}
+
+// loadFunctionManifest reads manifest.json from dir. A missing manifest is
+// not an error: the function just runs with the default limits.
+func loadFunctionManifest(dir string) (*FunctionManifest, error) {
+	path := filepath.Join(dir, "manifest.json")
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &FunctionManifest{}, nil
+		}
+		return nil, fmt.Errorf("error reading manifest %s: %v", path, err)
+	}
+
+	var manifest FunctionManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %s: %v", path, err)
+	}
+
+	return &manifest, nil
+}
+
+// limitsFromManifest overlays a manifest's overrides onto the defaults.
+func limitsFromManifest(manifest *FunctionManifest) ExecutionLimits {
+	limits := defaultExecutionLimits()
+	if manifest.TimeoutMS > 0 {
+		limits.TimeoutMS = manifest.TimeoutMS
+	}
+	if manifest.MemoryLimitBytes > 0 {
+		limits.MemoryLimitBytes = manifest.MemoryLimitBytes
+	}
+	return limits
+}
+
+// loadLimitsForFunction loads the manifest next to a function's func.js and
+// stores the resulting limits under its topic, falling back to the
+// defaults (and logging a warning) if the manifest can't be read.
+func loadLimitsForFunction(limitsStore *LimitsStore, function JavaScriptFunction) {
+	manifest, err := loadFunctionManifest(filepath.Dir(function.Path))
+	if err != nil {
+		appLogger.Warn().Err(err).Str("topic", function.Topic).Msg("Failed to load manifest, using default limits")
+		limitsStore.Set(function.Topic, defaultExecutionLimits())
+		return
+	}
+	limitsStore.Set(function.Topic, limitsFromManifest(manifest))
+}
+
+// deadlineTimer arms a single timer per execution and exposes a channel
+// that closes when the deadline elapses, mirroring the pattern used by
+// netstack's deadlineTimer: one deadlineTimer reused across executions, with
+// a fresh timer armed on start and stopped on normal completion.
+type deadlineTimer struct {
+	timer *time.Timer
+	c     chan struct{}
+}
+
+func newDeadlineTimer() *deadlineTimer {
+	return &deadlineTimer{}
+}
+
+// arm starts the timer and returns the channel that closes on expiry.
+func (dt *deadlineTimer) arm(d time.Duration) <-chan struct{} {
+	c := make(chan struct{})
+	dt.c = c
+	dt.timer = time.AfterFunc(d, func() { close(c) })
+	return c
+}
+
+// disarm stops the timer and resets the channel so a future arm starts
+// clean; it is a no-op if the timer already fired or was never armed.
+func (dt *deadlineTimer) disarm() {
+	if dt.timer != nil {
+		dt.timer.Stop()
+	}
+	dt.timer = nil
+	dt.c = nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,9 +11,11 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
@@ -24,6 +27,7 @@ import (
 type MessageEnvelope struct {
 	Content       json.RawMessage `json:"content"`
 	CorrelationID string          `json:"correlation_id,omitempty"`
+	ReplyTo       string          `json:"reply_to,omitempty"`
 }
 
 // Global loggers
@@ -37,6 +41,12 @@ type JavaScriptFunction struct {
 	Code    string
 	Program *goja.Program
 	Topic   string
+
+	// Loop is the persistent event loop backing this function's runtime. It
+	// is created when the function's Redis subscription starts and lives
+	// for as long as the function is deployed, so host bindings can return
+	// Promises that resolve from goroutines instead of blocking the VM.
+	Loop *eventloop.EventLoop
 }
 
 // Store functions with mutex for thread-safe updates
@@ -67,28 +77,49 @@ func (fs *FunctionStore) Set(topic string, function JavaScriptFunction) {
 func (fs *FunctionStore) Delete(topic string) {
 	fs.Lock()
 	defer fs.Unlock()
+	// Don't stop the Loop here: subscribeToRedis is its only owner and
+	// already calls loop.Stop() via its own defer once its next store.Get
+	// sees the function is gone. Calling Stop() a second time on an
+	// already-stopped loop deadlocks forever.
 	delete(fs.functions, topic)
 }
 
-// Strip correlation ID and get raw content for JavaScript
-func unwrapMessage(payload string) (string, string) {
+// unwrapMessage extracts the correlation ID and reply-to inbox (if any)
+// from a pub/sub payload and returns the raw content a handler should see.
+// Two envelope shapes are in play: publish() merges correlation_id directly
+// into the content's top-level JSON object (other content is nested under
+// "content"), while RPCBus.Call nests the caller's payload under a
+// "content" field alongside correlation_id and reply_to.
+func unwrapMessage(payload string) (content string, correlationID string, replyTo string) {
+	correlationID = generateCorrelationID() // default if not found
+
 	var envelope MessageEnvelope
-	correlationID := generateCorrelationID() // default if not found
-
-	if err := json.Unmarshal([]byte(payload), &envelope); err == nil {
-		if envelope.CorrelationID != "" {
-			correlationID = envelope.CorrelationID
-			// Remove correlation_id from payload but keep the rest
-			var rawMsg map[string]interface{}
-			if err := json.Unmarshal([]byte(payload), &rawMsg); err == nil {
-				delete(rawMsg, "correlation_id")
-				if newPayload, err := json.Marshal(rawMsg); err == nil {
-					return string(newPayload), correlationID
-				}
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		return payload, correlationID, ""
+	}
+
+	if envelope.CorrelationID != "" {
+		correlationID = envelope.CorrelationID
+	}
+	replyTo = envelope.ReplyTo
+
+	if len(envelope.Content) > 0 && string(envelope.Content) != "null" {
+		return string(envelope.Content), correlationID, replyTo
+	}
+
+	if envelope.CorrelationID != "" {
+		// Remove correlation_id (and reply_to) from payload but keep the rest
+		var rawMsg map[string]interface{}
+		if err := json.Unmarshal([]byte(payload), &rawMsg); err == nil {
+			delete(rawMsg, "correlation_id")
+			delete(rawMsg, "reply_to")
+			if newPayload, err := json.Marshal(rawMsg); err == nil {
+				return string(newPayload), correlationID, replyTo
 			}
 		}
 	}
-	return payload, correlationID // return original if not our format
+
+	return payload, correlationID, replyTo
 }
 
 // Wrap content with correlation ID when publishing
@@ -209,7 +240,7 @@ func findJavaScriptFiles(root string) ([]JavaScriptFunction, error) {
 	return functions, err
 }
 
-func watchFunctions(ctx context.Context, root string, store *FunctionStore, rdb *redis.Client) error {
+func watchFunctions(ctx context.Context, root string, store *FunctionStore, rdb *redis.Client, fetchCfg *FetchConfig, limitsStore *LimitsStore, rpcBus *RPCBus, blobStore *BlobStore, keyWatcher *KeyWatcher) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return fmt.Errorf("error creating watcher: %v", err)
@@ -269,13 +300,23 @@ func watchFunctions(ctx context.Context, root string, store *FunctionStore, rdb
 					appLogger.Error().Err(err).Str("file", event.Name).Msg("Error reloading function")
 					continue
 				}
+
+				// A code-only reload doesn't touch the live subscription, so
+				// carry its event loop forward; otherwise this clobbers the
+				// stored Loop with nil while the subscription's loop is still
+				// running.
+				if existing, ok := store.Get(function.Topic); ok {
+					function.Loop = existing.Loop
+				}
+
 				store.Set(function.Topic, *function)
+				loadLimitsForFunction(limitsStore, *function)
 				appLogger.Info().Str("topic", function.Topic).Msg("Reloaded function")
 
 				// Start a new subscription for created functions
 				if event.Op&fsnotify.Create != 0 {
 					go func(f JavaScriptFunction) {
-						if err := subscribeToRedis(ctx, rdb, f, store); err != nil {
+						if err := subscribeToRedis(ctx, rdb, f, store, fetchCfg, limitsStore, rpcBus, blobStore, keyWatcher); err != nil {
 							appLogger.Error().Err(err).Str("topic", f.Topic).Msg("Error in Redis subscription")
 						}
 					}(*function)
@@ -307,7 +348,27 @@ func watchFunctions(ctx context.Context, root string, store *FunctionStore, rdb
 		}
 	}
 }
-func subscribeToRedis(ctx context.Context, client *redis.Client, function JavaScriptFunction, store *FunctionStore) error {
+
+// execState holds the per-execution values that host bindings registered on
+// a function's persistent runtime need to read. The event loop runs one job
+// at a time, so mutating it right before each invocation is safe even though
+// the bindings themselves are only set up once.
+type execState struct {
+	ctx           context.Context
+	correlationID string
+	replyTo       string
+	execLogger    zerolog.Logger
+	consoleOutput []string
+}
+
+// registerHostBindings wires a function's selected modules onto rt. It runs
+// once per function, on the function's event loop, rather than once per
+// message, since rt now persists across invocations.
+func registerHostBindings(rt *goja.Runtime, ec ExecutionContext, moduleNames []string) {
+	registerModules(rt, ec, moduleNames)
+}
+
+func subscribeToRedis(ctx context.Context, client *redis.Client, function JavaScriptFunction, store *FunctionStore, fetchCfg *FetchConfig, limitsStore *LimitsStore, rpcBus *RPCBus, blobStore *BlobStore, keyWatcher *KeyWatcher) error {
 	pubsub := client.Subscribe(ctx, function.Topic)
 	defer pubsub.Close()
 
@@ -315,6 +376,60 @@ func subscribeToRedis(ctx context.Context, client *redis.Client, function JavaSc
 
 	appLogger.Info().Str("topic", function.Topic).Msg("Listening for messages")
 
+	// One event loop, and one underlying goja.Runtime, for the lifetime of
+	// this function: bindings are registered once below instead of being
+	// rebuilt for every message, and host calls can return Promises that
+	// resolve from goroutines without blocking the runtime.
+	loop := eventloop.NewEventLoop()
+	loop.Start()
+	defer loop.Stop()
+	// Drop any key watchers this function registered before its loop stops,
+	// so the shared KeyWatcher never dispatches onto a stopped runtime.
+	defer keyWatcher.UnwatchAllForTopic(function.Topic)
+
+	current := &execState{}
+
+	fetchClient, err := fetchCfg.httpClient()
+	if err != nil {
+		return fmt.Errorf("error building fetch client for %s: %v", function.Topic, err)
+	}
+
+	manifest, err := loadFunctionManifest(filepath.Dir(function.Path))
+	if err != nil {
+		appLogger.Warn().Err(err).Str("topic", function.Topic).Msg("Failed to load manifest, using default modules")
+		manifest = &FunctionManifest{}
+	}
+	moduleNames := resolveModuleNames(function, manifest)
+
+	ec := ExecutionContext{
+		Ctx:         ctx,
+		Redis:       client,
+		Loop:        loop,
+		FetchClient: fetchClient,
+		FetchCfg:    fetchCfg,
+		RPCBus:      rpcBus,
+		Blobs:       blobStore,
+		KeyWatcher:  keyWatcher,
+		LimitsStore: limitsStore,
+		Topic:       function.Topic,
+		Current:     current,
+	}
+
+	registered := make(chan struct{})
+	loop.RunOnLoop(func(rt *goja.Runtime) {
+		registerHostBindings(rt, ec, moduleNames)
+		close(registered)
+	})
+	<-registered
+
+	function.Loop = loop
+	store.Set(function.Topic, function)
+
+	// One deadlineTimer reused across executions: armed at the start of
+	// each invocation, disarmed on completion, mirroring the pattern used
+	// by netstack's deadlineTimer.
+	dt := newDeadlineTimer()
+
 	for msg := range ch {
 		// Get the latest version of the function
 		function, ok := store.Get(function.Topic)
@@ -323,8 +438,8 @@ func subscribeToRedis(ctx context.Context, client *redis.Client, function JavaSc
 			return nil
 		}
 
-		// Extract correlation ID and unwrap content
-		content, correlationID := unwrapMessage(msg.Payload)
+		// Extract correlation ID, reply-to inbox, and unwrap content
+		content, correlationID, replyTo := unwrapMessage(msg.Payload)
 
 		// Start execution logging
 		executionID := generateExecutionID()
@@ -340,146 +455,90 @@ func subscribeToRedis(ctx context.Context, client *redis.Client, function JavaSc
 
 		execLogger.Info().Str("content", content).Msg("Starting function execution")
 
-		// Collect console output
-		var consoleOutput []string
-
-		// Create a new runtime for each execution to avoid state persistence
-		rt := goja.New()
+		done := make(chan struct{})
+		loop.RunOnLoop(func(rt *goja.Runtime) {
+			current.correlationID = correlationID
+			current.replyTo = replyTo
+			current.execLogger = execLogger
+			current.consoleOutput = nil
 
-		// Add these right after setting up the publish function
-		// Set up Redis get/set functions
-		rt.Set("retrieveKey", func(call goja.FunctionCall) goja.Value {
-			if len(call.Arguments) != 1 {
-				panic(rt.NewTypeError("redisGet requires one argument: key"))
+			messageObj := map[string]interface{}{
+				"payload": content,
+				"topic":   function.Topic,
 			}
+			rt.Set("message", messageObj)
+
+			limits := limitsStore.Get(function.Topic)
+			// goja has no heap-limiting API (SetMemoryLimit doesn't exist),
+			// so MemoryLimitBytes is enforced as a call-stack-depth cap
+			// instead, which at least bounds runaway recursion.
+			rt.SetMaxCallStackSize(limits.maxCallStackSize())
+
+			// rt.Interrupt only aborts currently-executing JS bytecode; it
+			// can't unblock a goroutine parked in a blocking Redis or
+			// filesystem call. Host bindings that hand off to a goroutine
+			// (kv, pubsub, blob) take current.ctx instead of the function's
+			// long-lived context, so a timeout also cancels whatever
+			// in-flight call they're waiting on.
+			deadlineCtx, cancelDeadline := context.WithTimeout(ctx, limits.timeout())
+			current.ctx = deadlineCtx
+
+			var timedOut int32
+			stopTimeoutWatch := make(chan struct{})
+			expired := dt.arm(limits.timeout())
+			go func() {
+				select {
+				case <-expired:
+					atomic.StoreInt32(&timedOut, 1)
+					rt.Interrupt("execution timeout")
+				case <-stopTimeoutWatch:
+				}
+			}()
 
-			key := call.Arguments[0].String()
-
-			result, err := client.Get(ctx, key).Result()
-			if err == redis.Nil {
-				return goja.Null()
-			}
-			if err != nil {
-				execLogger.Error().Err(err).Str("key", key).Msg("Failed to get Redis key")
-				panic(rt.NewTypeError(fmt.Sprintf("failed to get key: %v", err)))
-			}
+			finish := func(err error) {
+				dt.disarm()
+				close(stopTimeoutWatch)
+				cancelDeadline()
 
-			execLogger.Info().Str("key", key).Msg("Retrieved Redis key")
-			return rt.ToValue(result)
-		})
-
-		rt.Set("storeKey", func(call goja.FunctionCall) goja.Value {
-			if len(call.Arguments) != 2 {
-				panic(rt.NewTypeError("redisSet requires two arguments: key and value"))
+				execDuration := time.Since(startTime)
+				timeout := atomic.LoadInt32(&timedOut) == 1
+				if err != nil {
+					execLogger.Error().
+						Err(err).
+						Bool("timeout", timeout).
+						Dur("duration", execDuration).
+						Strs("console_output", current.consoleOutput).
+						Msg("Function execution failed")
+				} else {
+					execLogger.Info().
+						Bool("timeout", timeout).
+						Dur("duration", execDuration).
+						Strs("console_output", current.consoleOutput).
+						Msg("Function execution completed")
+				}
+				close(done)
 			}
 
-			key := call.Arguments[0].String()
-			value := call.Arguments[1].String()
-
-			err := client.Set(ctx, key, value, 0).Err() // 0 means no expiration
+			result, err := rt.RunProgram(function.Program)
 			if err != nil {
-				execLogger.Error().Err(err).Str("key", key).Msg("Failed to set Redis key")
-				panic(rt.NewTypeError(fmt.Sprintf("failed to set key: %v", err)))
+				finish(err)
+				return
 			}
 
-			execLogger.Info().Str("key", key).Msg("Set Redis key")
-			return goja.Undefined()
-		})
-
-		// Set up publish function with correlation ID
-		rt.Set("publish", func(call goja.FunctionCall) goja.Value {
-			if len(call.Arguments) != 2 {
-				panic(rt.NewTypeError("publish requires two arguments: topic and message"))
+			// If the handler returned a Promise (e.g. an `async function
+			// handle`), await it so completion is logged once the user's
+			// awaited work actually finishes rather than when the
+			// synchronous portion of the handler returns.
+			if isPromise(result) {
+				awaitPromise(rt, result, func(_ goja.Value, err error) {
+					finish(err)
+				})
+				return
 			}
 
-			topic := call.Arguments[0].String()
-			message := call.Arguments[1].String()
-
-			// Wrap the message with correlation ID
-			wrappedMessage := wrapMessage(message, correlationID)
-
-			execLogger.Info().
-				Str("publish_topic", topic).
-				Str("message", message).
-				Msg("Publishing message")
-
-			err := client.Publish(ctx, topic, wrappedMessage).Err()
-			if err != nil {
-				execLogger.Error().Err(err).Msg("Failed to publish message")
-				panic(rt.NewTypeError(fmt.Sprintf("failed to publish message: %v", err)))
-			}
-
-			return goja.Undefined()
+			finish(nil)
 		})
-
-		// Set up console.log and other console methods with correlation ID
-		console := map[string]interface{}{
-			"log": func(call goja.FunctionCall) goja.Value {
-				args := make([]interface{}, len(call.Arguments))
-				for i, arg := range call.Arguments {
-					args[i] = arg.String()
-				}
-				output := fmt.Sprint(args...)
-				consoleOutput = append(consoleOutput, output)
-				execLogger.Info().
-					Str("level", "log").
-					Str("output", output).
-					Msg("Console output")
-				return goja.Undefined()
-			},
-			"error": func(call goja.FunctionCall) goja.Value {
-				args := make([]interface{}, len(call.Arguments))
-				for i, arg := range call.Arguments {
-					args[i] = arg.String()
-				}
-				output := fmt.Sprint(args...)
-				consoleOutput = append(consoleOutput, "ERROR: "+output)
-				execLogger.Error().
-					Str("level", "error").
-					Str("output", output).
-					Msg("Console output")
-				return goja.Undefined()
-			},
-			"warn": func(call goja.FunctionCall) goja.Value {
-				args := make([]interface{}, len(call.Arguments))
-				for i, arg := range call.Arguments {
-					args[i] = arg.String()
-				}
-				output := fmt.Sprint(args...)
-				consoleOutput = append(consoleOutput, "WARN: "+output)
-				execLogger.Warn().
-					Str("level", "warn").
-					Str("output", output).
-					Msg("Console output")
-				return goja.Undefined()
-			},
-		}
-		rt.Set("console", console)
-
-		// Set up the message object with unwrapped content
-		messageObj := map[string]interface{}{
-			"payload": content,
-			"topic":   function.Topic,
-		}
-		rt.Set("message", messageObj)
-
-		// Execute the precompiled program
-		_, err := rt.RunProgram(function.Program)
-		execDuration := time.Since(startTime)
-
-		if err != nil {
-			execLogger.Error().
-				Err(err).
-				Dur("duration", execDuration).
-				Strs("console_output", consoleOutput).
-				Msg("Function execution failed")
-			continue
-		}
-
-		execLogger.Info().
-			Dur("duration", execDuration).
-			Strs("console_output", consoleOutput).
-			Msg("Function execution completed")
+		<-done
 	}
 
 	return nil
@@ -490,7 +549,7 @@ type FunctionRequest struct {
 	Code  string `json:"code"`
 }
 
-func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Client, watcher *fsnotify.Watcher) *echo.Echo {
+func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Client, watcher *fsnotify.Watcher, fetchCfg *FetchConfig, limitsStore *LimitsStore, rpcBus *RPCBus, blobStore *BlobStore, keyWatcher *KeyWatcher) *echo.Echo {
 	e := echo.New()
 
 	// Middleware
@@ -528,6 +587,7 @@ func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Clien
 		// Store the function
 		// Store the function and start subscription
 		store.Set(function.Topic, *function)
+		loadLimitsForFunction(limitsStore, *function)
 
 		// Add the new directory to the watcher
 		if err := watcher.Add(funcPath); err != nil {
@@ -536,7 +596,7 @@ func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Clien
 
 		// Start Redis subscription for the new function
 		go func(f JavaScriptFunction) {
-			err := subscribeToRedis(ctx, rdb, f, store)
+			err := subscribeToRedis(ctx, rdb, f, store, fetchCfg, limitsStore, rpcBus, blobStore, keyWatcher)
 			if err != nil {
 				appLogger.Error().Err(err).Str("topic", f.Topic).Msg("Error in Redis subscription")
 			}
@@ -558,6 +618,7 @@ func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Clien
 		// Clean up resources
 		if _, ok := store.Get(topic); ok {
 			store.Delete(topic)
+			limitsStore.Delete(topic)
 
 			// Remove the function directory
 			funcPath := filepath.Join("functions", topic)
@@ -610,10 +671,110 @@ func setupHTTPServer(store *FunctionStore, ctx context.Context, rdb *redis.Clien
 		})
 	})
 
+	// Inspect a function's execution limits
+	e.GET("/api/functions/:topic/limits", func(c echo.Context) error {
+		topic := c.Param("topic")
+		if _, exists := store.Get(topic); !exists {
+			return echo.NewHTTPError(http.StatusNotFound, "Function not found")
+		}
+
+		return c.JSON(http.StatusOK, limitsStore.Get(topic))
+	})
+
+	// Update a function's execution limits without redeploying its code
+	e.PUT("/api/functions/:topic/limits", func(c echo.Context) error {
+		topic := c.Param("topic")
+		if _, exists := store.Get(topic); !exists {
+			return echo.NewHTTPError(http.StatusNotFound, "Function not found")
+		}
+
+		var limits ExecutionLimits
+		if err := c.Bind(&limits); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid request: %v", err))
+		}
+		if limits.TimeoutMS <= 0 || limits.MemoryLimitBytes <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "timeout_ms and memory_limit_bytes must be positive")
+		}
+
+		limitsStore.Set(topic, limits)
+		appLogger.Info().Str("topic", topic).Interface("limits", limits).Msg("Updated execution limits")
+
+		return c.JSON(http.StatusOK, limits)
+	})
+
+	// Upload a blob. The body is the blob content, read fully into memory
+	// and then written to the backend (it is not streamed); Content-Type and
+	// an optional ?topic= query param become its stored metadata. The
+	// request body is capped at blobStore's configured max upload size.
+	e.POST("/api/blobs", func(c echo.Context) error {
+		req := c.Request()
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, blobStore.maxUploadSize)
+
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Failed to read body: %v", err))
+		}
+
+		contentType := req.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		id, err := blobStore.Put(ctx, data, contentType, c.QueryParam("topic"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Failed to store blob: %v", err))
+		}
+
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"id":           id,
+			"size":         len(data),
+			"content_type": contentType,
+		})
+	})
+
+	// Download a blob: its whole content is read into memory and written
+	// back in one response with its stored Content-Type (it is not streamed).
+	e.GET("/api/blobs/:id", func(c echo.Context) error {
+		id := c.Param("id")
+
+		data, meta, err := blobStore.Get(ctx, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to read blob: %v", err))
+		}
+		if meta == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "Blob not found")
+		}
+
+		return c.Blob(http.StatusOK, meta.ContentType, data)
+	})
+
+	// Drop a reference to a blob. Content is only actually removed once no
+	// references remain.
+	e.DELETE("/api/blobs/:id", func(c echo.Context) error {
+		id := c.Param("id")
+
+		existed, err := blobStore.Delete(ctx, id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to delete blob: %v", err))
+		}
+		if !existed {
+			return echo.NewHTTPError(http.StatusNotFound, "Blob not found")
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"status": "deleted",
+			"id":     id,
+		})
+	})
+
 	return e
 }
 
 func main() {
+	maxUploadSize := flag.Int64("max-upload-size", 10*1024*1024, "maximum accepted size, in bytes, for a single blob upload")
+	blobDir := flag.String("blob-dir", "blobs", "directory used by the filesystem blob backend")
+	flag.Parse()
+
 	// Set up logging
 	if err := setupLogging(); err != nil {
 		fmt.Printf("Failed to setup logging: %v\n", err)
@@ -635,9 +796,39 @@ func main() {
 		appLogger.Fatal().Err(err).Msg("Failed to connect to Redis")
 	}
 
+	// Load fetch() settings for JS functions (allowed hosts, timeouts, proxy)
+	fetchCfg, err := loadFetchConfig("fetch.json")
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("Error loading fetch config")
+	}
+	initFetchSemaphore(fetchCfg.MaxConcurrentFetches)
+
+	// Start the request/reply bus: one dedicated subscriber for this
+	// node's reply inbox, shared by every function's rpc()/reply() calls
+	rpcBus := NewRPCBus(rdb)
+	if err := rpcBus.Start(ctx); err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to start rpc bus")
+	}
+
 	// Create function store
 	store := NewFunctionStore()
 
+	// Create the execution limits store (timeouts, memory caps)
+	limitsStore := NewLimitsStore()
+
+	// Create the blob store (content-addressed, ref-counted, filesystem-backed)
+	blobBackend, err := newFilesystemBlobBackend(*blobDir)
+	if err != nil {
+		appLogger.Fatal().Err(err).Msg("Failed to set up blob backend")
+	}
+	blobStore := NewBlobStore(blobBackend, rdb, *maxUploadSize)
+
+	// Start the keyspace-notification watcher: one shared subscription that
+	// watchKey() bindings register glob patterns against. Requires Redis's
+	// notify-keyspace-events configured to publish key-space events (e.g. "KEA").
+	keyWatcher := NewKeyWatcher(rdb)
+	keyWatcher.Start(ctx)
+
 	// Find all JavaScript functions
 	functions, err := findJavaScriptFiles("./functions")
 	if err != nil {
@@ -647,6 +838,7 @@ func main() {
 	// Initialize the store
 	for _, function := range functions {
 		store.Set(function.Topic, function)
+		loadLimitsForFunction(limitsStore, function)
 	}
 
 	appLogger.Info().Int("count", len(functions)).Msg("Found JavaScript functions")
@@ -660,7 +852,7 @@ func main() {
 
 	// Start the file watcher
 	go func() {
-		if err := watchFunctions(ctx, "./functions", store, rdb); err != nil {
+		if err := watchFunctions(ctx, "./functions", store, rdb, fetchCfg, limitsStore, rpcBus, blobStore, keyWatcher); err != nil {
 			appLogger.Error().Err(err).Msg("Error in file watcher")
 		}
 	}()
@@ -668,7 +860,7 @@ func main() {
 	// Subscribe to Redis topics for each function
 	for _, function := range functions {
 		go func(f JavaScriptFunction) {
-			err := subscribeToRedis(ctx, rdb, f, store)
+			err := subscribeToRedis(ctx, rdb, f, store, fetchCfg, limitsStore, rpcBus, blobStore, keyWatcher)
 			if err != nil {
 				appLogger.Error().Err(err).Str("topic", f.Topic).Msg("Error in Redis subscription")
 			}
@@ -676,7 +868,7 @@ func main() {
 	}
 
 	// Start HTTP server
-	e := setupHTTPServer(store, ctx, rdb, watcher)
+	e := setupHTTPServer(store, ctx, rdb, watcher, fetchCfg, limitsStore, rpcBus, blobStore, keyWatcher)
 	appLogger.Info().Msg("HTTP server listening on :8080")
 	if err := e.Start(":8080"); err != nil && err != http.ErrServerClosed {
 		appLogger.Fatal().Err(err).Msg("HTTP server error")
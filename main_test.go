@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestUnwrapMessageLegacyShape(t *testing.T) {
+	content, correlationID, replyTo := unwrapMessage(`{"foo":"bar","correlation_id":"corr_1"}`)
+
+	if content != `{"foo":"bar"}` {
+		t.Errorf("content = %q, want %q", content, `{"foo":"bar"}`)
+	}
+	if correlationID != "corr_1" {
+		t.Errorf("correlationID = %q, want %q", correlationID, "corr_1")
+	}
+	if replyTo != "" {
+		t.Errorf("replyTo = %q, want empty", replyTo)
+	}
+}
+
+func TestUnwrapMessageNestedShape(t *testing.T) {
+	content, correlationID, replyTo := unwrapMessage(`{"content":{"foo":"bar"},"correlation_id":"corr_2","reply_to":"inbox.node.corr_2"}`)
+
+	if content != `{"foo":"bar"}` {
+		t.Errorf("content = %q, want %q", content, `{"foo":"bar"}`)
+	}
+	if correlationID != "corr_2" {
+		t.Errorf("correlationID = %q, want %q", correlationID, "corr_2")
+	}
+	if replyTo != "inbox.node.corr_2" {
+		t.Errorf("replyTo = %q, want %q", replyTo, "inbox.node.corr_2")
+	}
+}
+
+func TestUnwrapMessageNotJSON(t *testing.T) {
+	content, correlationID, replyTo := unwrapMessage("plain text")
+
+	if content != "plain text" {
+		t.Errorf("content = %q, want %q", content, "plain text")
+	}
+	if correlationID == "" {
+		t.Error("correlationID should be generated when absent")
+	}
+	if replyTo != "" {
+		t.Errorf("replyTo = %q, want empty", replyTo)
+	}
+}
+
+func TestWrapMessageJSONContent(t *testing.T) {
+	wrapped := wrapMessage(`{"foo":"bar"}`, "corr_3")
+
+	content, correlationID, _ := unwrapMessage(wrapped)
+	if correlationID != "corr_3" {
+		t.Errorf("correlationID = %q, want %q", correlationID, "corr_3")
+	}
+	if content != `{"foo":"bar"}` {
+		t.Errorf("content = %q, want %q", content, `{"foo":"bar"}`)
+	}
+}
+
+func TestWrapMessageNonObjectJSONContent(t *testing.T) {
+	wrapped := wrapMessage(`"hello"`, "corr_4")
+
+	content, correlationID, _ := unwrapMessage(wrapped)
+	if correlationID != "corr_4" {
+		t.Errorf("correlationID = %q, want %q", correlationID, "corr_4")
+	}
+	if content != `"hello"` {
+		t.Errorf("content = %q, want %q", content, `"hello"`)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
+	"github.com/go-redis/redis/v8"
+)
+
+// ExecutionContext bundles the dependencies a Module needs to register its
+// bindings. It is built once per function, when its event loop starts.
+type ExecutionContext struct {
+	Ctx         context.Context
+	Redis       *redis.Client
+	Loop        *eventloop.EventLoop
+	FetchClient *http.Client
+	FetchCfg    *FetchConfig
+	RPCBus      *RPCBus
+	Blobs       *BlobStore
+	KeyWatcher  *KeyWatcher
+	LimitsStore *LimitsStore
+	Topic       string
+	Current     *execState
+}
+
+// Module is a host capability a function's runtime can expose to JS, such
+// as key/value access or outbound HTTP. Functions opt into a subset of the
+// built-ins via a manifest.json "modules" list or a leading
+// "// @modules: kv,fetch" comment, so new capabilities can be added without
+// touching the subscription loop, and untrusted topics can be denied ones
+// like fetch.
+type Module interface {
+	Name() string
+	Register(rt *goja.Runtime, ec ExecutionContext) error
+}
+
+func builtinModules() []Module {
+	return []Module{
+		kvModule{},
+		pubsubModule{},
+		consoleModule{},
+		fetchJSModule{},
+		rpcModule{},
+		blobModule{},
+		keyWatchModule{},
+	}
+}
+
+func defaultModuleNames() []string {
+	names := make([]string, 0, len(builtinModules()))
+	for _, m := range builtinModules() {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+// resolveModuleNames decides which modules a function gets, preferring an
+// explicit manifest.json list, then a "// @modules:" comment header, and
+// falling back to every built-in module for functions that specify neither.
+func resolveModuleNames(function JavaScriptFunction, manifest *FunctionManifest) []string {
+	if len(manifest.Modules) > 0 {
+		return manifest.Modules
+	}
+	if mods := parseModulesComment(function.Code); mods != nil {
+		return mods
+	}
+	return defaultModuleNames()
+}
+
+// parseModulesComment looks for a "// @modules: kv,fetch" header in the
+// first few lines of a function's source and returns the listed module
+// names, or nil if no such header is present.
+func parseModulesComment(code string) []string {
+	const marker = "// @modules:"
+
+	lines := strings.SplitN(code, "\n", 10)
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, marker) {
+			continue
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(trimmed, marker))
+		if rest == "" {
+			return nil
+		}
+		parts := strings.Split(rest, ",")
+		names := make([]string, 0, len(parts))
+		for _, p := range parts {
+			if name := strings.TrimSpace(p); name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// registerModules registers the named modules onto rt, skipping (and
+// logging) any name that doesn't match a built-in.
+func registerModules(rt *goja.Runtime, ec ExecutionContext, names []string) {
+	modules := builtinModules()
+
+	for _, name := range names {
+		var found Module
+		for _, m := range modules {
+			if m.Name() == name {
+				found = m
+				break
+			}
+		}
+		if found == nil {
+			appLogger.Warn().Str("module", name).Msg("Unknown module requested, skipping")
+			continue
+		}
+		if err := found.Register(rt, ec); err != nil {
+			appLogger.Error().Err(err).Str("module", name).Msg("Failed to register module")
+		}
+	}
+}
+
+// kvModule exposes retrieveKey/storeKey for reading and writing Redis keys.
+// Both resolve a Promise from a goroutine rather than blocking the event
+// loop, matching the pattern fetch()/rpc() use.
+type kvModule struct{}
+
+func (kvModule) Name() string { return "kv" }
+
+func (kvModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	current := ec.Current
+	client := ec.Redis
+	loop := ec.Loop
+
+	rt.Set("retrieveKey", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 1 {
+			panic(rt.NewTypeError("redisGet requires one argument: key"))
+		}
+
+		key := call.Arguments[0].String()
+		ctx := current.ctx
+		execLogger := current.execLogger
+
+		promise, resolve, reject := rt.NewPromise()
+
+		go func() {
+			result, err := client.Get(ctx, key).Result()
+			loop.RunOnLoop(func(rt *goja.Runtime) {
+				if err == redis.Nil {
+					resolve(goja.Null())
+					return
+				}
+				if err != nil {
+					execLogger.Error().Err(err).Str("key", key).Msg("Failed to get Redis key")
+					reject(rt.NewTypeError("failed to get key: " + err.Error()))
+					return
+				}
+				execLogger.Info().Str("key", key).Msg("Retrieved Redis key")
+				resolve(rt.ToValue(result))
+			})
+		}()
+
+		return rt.ToValue(promise)
+	})
+
+	rt.Set("storeKey", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 2 {
+			panic(rt.NewTypeError("redisSet requires two arguments: key and value"))
+		}
+
+		key := call.Arguments[0].String()
+		value := call.Arguments[1].String()
+		ctx := current.ctx
+		execLogger := current.execLogger
+
+		promise, resolve, reject := rt.NewPromise()
+
+		go func() {
+			err := client.Set(ctx, key, value, 0).Err()
+			loop.RunOnLoop(func(rt *goja.Runtime) {
+				if err != nil {
+					execLogger.Error().Err(err).Str("key", key).Msg("Failed to set Redis key")
+					reject(rt.NewTypeError("failed to set key: " + err.Error()))
+					return
+				}
+				execLogger.Info().Str("key", key).Msg("Set Redis key")
+				resolve(goja.Undefined())
+			})
+		}()
+
+		return rt.ToValue(promise)
+	})
+
+	return nil
+}
+
+// pubsubModule exposes publish for sending messages to other topics. It
+// resolves a Promise from a goroutine rather than blocking the event loop.
+type pubsubModule struct{}
+
+func (pubsubModule) Name() string { return "pubsub" }
+
+func (pubsubModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	current := ec.Current
+	client := ec.Redis
+	loop := ec.Loop
+
+	rt.Set("publish", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 2 {
+			panic(rt.NewTypeError("publish requires two arguments: topic and message"))
+		}
+
+		topic := call.Arguments[0].String()
+		message := call.Arguments[1].String()
+		ctx := current.ctx
+		execLogger := current.execLogger
+
+		wrappedMessage := wrapMessage(message, current.correlationID)
+
+		execLogger.Info().
+			Str("publish_topic", topic).
+			Str("message", message).
+			Msg("Publishing message")
+
+		promise, resolve, reject := rt.NewPromise()
+
+		go func() {
+			err := client.Publish(ctx, topic, wrappedMessage).Err()
+			loop.RunOnLoop(func(rt *goja.Runtime) {
+				if err != nil {
+					execLogger.Error().Err(err).Msg("Failed to publish message")
+					reject(rt.NewTypeError("failed to publish message: " + err.Error()))
+					return
+				}
+				resolve(goja.Undefined())
+			})
+		}()
+
+		return rt.ToValue(promise)
+	})
+
+	return nil
+}
+
+// consoleModule exposes console.log/error/warn, collecting output for the
+// execution log.
+type consoleModule struct{}
+
+func (consoleModule) Name() string { return "console" }
+
+func (consoleModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	current := ec.Current
+
+	logLine := func(call goja.FunctionCall) string {
+		args := make([]interface{}, len(call.Arguments))
+		for i, arg := range call.Arguments {
+			args[i] = arg.String()
+		}
+		return fmt.Sprint(args...)
+	}
+
+	console := map[string]interface{}{
+		"log": func(call goja.FunctionCall) goja.Value {
+			output := logLine(call)
+			current.consoleOutput = append(current.consoleOutput, output)
+			current.execLogger.Info().Str("level", "log").Str("output", output).Msg("Console output")
+			return goja.Undefined()
+		},
+		"error": func(call goja.FunctionCall) goja.Value {
+			output := logLine(call)
+			current.consoleOutput = append(current.consoleOutput, "ERROR: "+output)
+			current.execLogger.Error().Str("level", "error").Str("output", output).Msg("Console output")
+			return goja.Undefined()
+		},
+		"warn": func(call goja.FunctionCall) goja.Value {
+			output := logLine(call)
+			current.consoleOutput = append(current.consoleOutput, "WARN: "+output)
+			current.execLogger.Warn().Str("level", "warn").Str("output", output).Msg("Console output")
+			return goja.Undefined()
+		},
+	}
+	rt.Set("console", console)
+
+	return nil
+}
+
+// fetchJSModule exposes fetch() for outbound HTTP, built on top of the
+// function's shared FetchConfig.
+type fetchJSModule struct{}
+
+func (fetchJSModule) Name() string { return "fetch" }
+
+func (fetchJSModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	registerFetch(rt, ec.Loop, ec.FetchClient, ec.FetchCfg, ec.Current)
+	return nil
+}
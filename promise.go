@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/dop251/goja"
+)
+
+// isPromise reports whether val is a JS Promise, so callers can decide
+// whether to await it before treating a handler invocation as complete.
+func isPromise(val goja.Value) bool {
+	if val == nil || goja.IsUndefined(val) || goja.IsNull(val) {
+		return false
+	}
+	_, ok := val.Export().(*goja.Promise)
+	return ok
+}
+
+// awaitPromise attaches then/catch handlers to val and invokes done once the
+// promise settles, passing the fulfilled value or the rejection reason as an
+// error. It must be called from the goroutine that owns rt's event loop,
+// since the then/catch callbacks run as loop jobs.
+func awaitPromise(rt *goja.Runtime, val goja.Value, done func(goja.Value, error)) {
+	obj := val.ToObject(rt)
+	then, ok := goja.AssertFunction(obj.Get("then"))
+	if !ok {
+		// Not a thenable after all; treat the value as already settled.
+		done(val, nil)
+		return
+	}
+
+	onFulfilled := rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		var result goja.Value
+		if len(call.Arguments) > 0 {
+			result = call.Arguments[0]
+		} else {
+			result = goja.Undefined()
+		}
+		done(result, nil)
+		return goja.Undefined()
+	})
+
+	onRejected := rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		var reason string
+		if len(call.Arguments) > 0 {
+			reason = call.Arguments[0].String()
+		}
+		done(goja.Undefined(), &promiseRejectionError{reason: reason})
+		return goja.Undefined()
+	})
+
+	if _, err := then(obj, onFulfilled, onRejected); err != nil {
+		done(goja.Undefined(), err)
+	}
+}
+
+// promiseRejectionError wraps a JS promise rejection reason as a Go error.
+type promiseRejectionError struct {
+	reason string
+}
+
+func (e *promiseRejectionError) Error() string {
+	return "promise rejected: " + e.reason
+}
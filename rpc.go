@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/go-redis/redis/v8"
+)
+
+// RPCBus turns the fire-and-forget pub/sub model into a request/response
+// bus. A caller publishes to a topic along with a reply-to inbox unique to
+// this call; the callee's `reply` binding publishes the answer back to
+// that inbox; RPCBus matches the answer to the waiting caller by
+// correlation ID. One RPCBus, and one dedicated subscriber to this
+// process's inbox pattern, is shared by every function.
+type RPCBus struct {
+	nodeID string
+	client *redis.Client
+
+	mu      sync.Mutex
+	pending map[string]chan rpcReply
+}
+
+type rpcReply struct {
+	payload string
+	err     error
+}
+
+func generateNodeID() string {
+	return fmt.Sprintf("node_%d", time.Now().UnixNano())
+}
+
+// NewRPCBus creates a bus for client. Call Start to begin listening for
+// replies before issuing any Call.
+func NewRPCBus(client *redis.Client) *RPCBus {
+	return &RPCBus{
+		nodeID:  generateNodeID(),
+		client:  client,
+		pending: make(map[string]chan rpcReply),
+	}
+}
+
+// inboxPattern is the shared subscription every reply for this node
+// arrives on: inbox.<nodeid>.<correlation id>.
+func (b *RPCBus) inboxPattern() string {
+	return fmt.Sprintf("inbox.%s.*", b.nodeID)
+}
+
+func (b *RPCBus) replyTo(correlationID string) string {
+	return fmt.Sprintf("inbox.%s.%s", b.nodeID, correlationID)
+}
+
+// Start opens the single dedicated subscription for this node's inbox and
+// dispatches incoming replies to whichever Call is waiting on them. It
+// should be called once at startup.
+func (b *RPCBus) Start(ctx context.Context) error {
+	pubsub := b.client.PSubscribe(ctx, b.inboxPattern())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("error subscribing to rpc inbox: %v", err)
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			correlationID := msg.Channel[strings.LastIndex(msg.Channel, ".")+1:]
+
+			b.mu.Lock()
+			waiter, ok := b.pending[correlationID]
+			if ok {
+				delete(b.pending, correlationID)
+			}
+			b.mu.Unlock()
+
+			if ok {
+				waiter <- rpcReply{payload: msg.Payload}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Call publishes payload to topic with a fresh reply-to inbox and blocks
+// until a matching reply arrives, timeout elapses, or ctx is done,
+// whichever is first. Stale entries (the timeout and ctx cases) are evicted
+// from pending immediately.
+func (b *RPCBus) Call(ctx context.Context, topic, payload string, timeout time.Duration) (string, error) {
+	correlationID := generateCorrelationID()
+	waiter := make(chan rpcReply, 1)
+
+	b.mu.Lock()
+	b.pending[correlationID] = waiter
+	b.mu.Unlock()
+
+	envelope := MessageEnvelope{
+		Content:       []byte(payload),
+		CorrelationID: correlationID,
+		ReplyTo:       b.replyTo(correlationID),
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		b.evict(correlationID)
+		return "", fmt.Errorf("error encoding rpc request: %v", err)
+	}
+
+	if err := b.client.Publish(ctx, topic, string(data)).Err(); err != nil {
+		b.evict(correlationID)
+		return "", fmt.Errorf("error publishing rpc request: %v", err)
+	}
+
+	select {
+	case reply := <-waiter:
+		return reply.payload, reply.err
+	case <-time.After(timeout):
+		b.evict(correlationID)
+		return "", fmt.Errorf("rpc call to %s timed out after %s", topic, timeout)
+	case <-ctx.Done():
+		b.evict(correlationID)
+		return "", fmt.Errorf("rpc call to %s cancelled: %v", topic, ctx.Err())
+	}
+}
+
+func (b *RPCBus) evict(correlationID string) {
+	b.mu.Lock()
+	delete(b.pending, correlationID)
+	b.mu.Unlock()
+}
+
+// rpcModule exposes rpc(topic, payload, timeoutMs) and reply(message):
+// request/response over the existing topic model instead of
+// fire-and-forget publish.
+type rpcModule struct{}
+
+func (rpcModule) Name() string { return "rpc" }
+
+func (rpcModule) Register(rt *goja.Runtime, ec ExecutionContext) error {
+	current := ec.Current
+	bus := ec.RPCBus
+	loop := ec.Loop
+	ctx := ec.Ctx
+
+	rt.Set("rpc", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) < 2 {
+			panic(rt.NewTypeError("rpc requires at least two arguments: topic and payload"))
+		}
+
+		topic := call.Arguments[0].String()
+		payload := call.Arguments[1].String()
+
+		timeout := 5 * time.Second
+		if len(call.Arguments) > 2 && !goja.IsUndefined(call.Arguments[2]) {
+			timeout = time.Duration(call.Arguments[2].ToInteger()) * time.Millisecond
+		}
+
+		promise, resolve, reject := rt.NewPromise()
+
+		current.execLogger.Info().
+			Str("rpc_topic", topic).
+			Dur("timeout", timeout).
+			Msg("Issuing rpc call")
+
+		// current.ctx carries the execution's deadline, not just the
+		// per-call timeout: this is what lets the execution-level deadline
+		// cut the call short too, the same way every other goroutine-backed
+		// binding (kv, pubsub, blob) does.
+		rpcCtx := current.ctx
+
+		go func() {
+			reply, err := bus.Call(rpcCtx, topic, payload, timeout)
+			loop.RunOnLoop(func(rt *goja.Runtime) {
+				if err != nil {
+					reject(rt.NewTypeError(err.Error()))
+					return
+				}
+				resolve(rt.ToValue(reply))
+			})
+		}()
+
+		return rt.ToValue(promise)
+	})
+
+	rt.Set("reply", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) != 1 {
+			panic(rt.NewTypeError("reply requires one argument: message"))
+		}
+		if current.replyTo == "" {
+			panic(rt.NewTypeError("reply() called outside of an rpc request"))
+		}
+
+		message := call.Arguments[0].String()
+
+		if err := ec.Redis.Publish(ctx, current.replyTo, message).Err(); err != nil {
+			current.execLogger.Error().Err(err).Msg("Failed to publish rpc reply")
+			panic(rt.NewTypeError("failed to send reply: " + err.Error()))
+		}
+
+		return goja.Undefined()
+	})
+
+	return nil
+}